@@ -0,0 +1,56 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AddressSource controls whether endpoint IPs are taken directly from pods or
+// from the node the pod is running on (e.g. when fronting a hostPort/NodePort).
+type AddressSource string
+
+const (
+	AddressSourcePod  AddressSource = "Pod"
+	AddressSourceNode AddressSource = "Node"
+)
+
+// IngressAddress is a single address reported back on Service.Status.LoadBalancer.Ingress.
+type IngressAddress struct {
+	IP       *netip.Addr
+	Hostname string
+}
+
+// Config holds the static, process-wide configuration of the controller.
+type Config struct {
+	LoadBalancerClass string
+	AddressSource     AddressSource
+	NodeAddressType   corev1.NodeAddressType
+	UseIPv6Endpoints  bool
+	IngressStatus     []IngressAddress
+	// ControlPlaneZone is the topology zone this controller instance runs in,
+	// sourced from the --control-plane-zone flag or the downward API. It
+	// drives topology-aware endpoint selection; left empty, topology hints
+	// are ignored.
+	ControlPlaneZone string
+	// ClusterID identifies this member cluster. It tags every locally
+	// discovered endpoint (for PROXY protocol / locality purposes), whether
+	// or not its Service also imports endpoints from other clusters.
+	ClusterID string
+}
@@ -18,10 +18,12 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/eplightning/xds-servicelb/internal"
 	"github.com/eplightning/xds-servicelb/internal/graph"
+	"github.com/eplightning/xds-servicelb/internal/healthcheck"
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
@@ -36,13 +38,63 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	proxyProtocolAnnotation = "xds-servicelb.eplight.org/use-proxy-protocol"
 	idleTimeoutAnnotation   = "xds-servicelb.eplight.org/idle-timeout"
+
+	topologyModeAnnotation          = "service.kubernetes.io/topology-mode"
+	topologyAwareHintsAnnotationOld = "service.kubernetes.io/topology-aware-hints"
+	topologyModeAuto                = "Auto"
+	topologyModeAutoDeprecated      = "auto"
+
+	importFromAnnotation = "xds-servicelb.eplight.org/import-from"
+
+	// multiClusterServiceNameLabel is placed by a ServiceExport mirroring
+	// controller on the EndpointSlices it replicates for a ServiceImport.
+	multiClusterServiceNameLabel = "multicluster.kubernetes.io/service-name"
+	// sourceClusterLabel optionally identifies which member cluster a
+	// mirrored EndpointSlice's endpoints originated from.
+	sourceClusterLabel = "multicluster.kubernetes.io/source-cluster"
+
+	protocolAnnotation        = "xds-servicelb.eplight.org/protocol"
+	tlsSNIRoutesAnnotation    = "xds-servicelb.eplight.org/tls-sni-routes"
+	httpAccessLogAnnotation   = "xds-servicelb.eplight.org/http-access-log"
+	connectionLimitAnnotation = "xds-servicelb.eplight.org/connection-limit"
+
+	healthCheckAnnotation                   = "xds-servicelb.eplight.org/healthcheck"
+	healthCheckIntervalAnnotation           = "xds-servicelb.eplight.org/healthcheck-interval"
+	healthCheckTimeoutAnnotation            = "xds-servicelb.eplight.org/healthcheck-timeout"
+	healthCheckUnhealthyThresholdAnnotation = "xds-servicelb.eplight.org/healthcheck-unhealthy-threshold"
+	healthCheckHealthyThresholdAnnotation   = "xds-servicelb.eplight.org/healthcheck-healthy-threshold"
+
+	portRangesAnnotation = "xds-servicelb.eplight.org/port-ranges"
+)
+
+// minPort, maxPort and maxPortRangeWidth bound the port-ranges annotation:
+// ports must fall inside the valid TCP/UDP port space, and a single range is
+// capped so a typo like "1-4000000000" can't expand into billions of
+// graph.ServicePort entries in the reconcile loop.
+const (
+	minPort           = 1
+	maxPort           = 65535
+	maxPortRangeWidth = 4096
+)
+
+// Conflict backoff bounds how fast Reconcile retries a Service stuck behind a
+// port conflict: it starts small so a transient race between two Services
+// resolves quickly, but backs off exponentially so a persistent conflict
+// doesn't spam the API server or the conflict Warning event.
+const (
+	conflictBackoffBase = 5 * time.Second
+	conflictBackoffMax  = 5 * time.Minute
 )
 
 var (
@@ -52,21 +104,28 @@ var (
 // ServiceReconciler reconciles a Service object
 type ServiceReconciler struct {
 	client.Client
-	scheme   *runtime.Scheme
-	graph    *graph.ServiceGraph
-	config   *internal.Config
-	recorder record.EventRecorder
+	scheme        *runtime.Scheme
+	graph         *graph.ServiceGraph
+	config        *internal.Config
+	recorder      record.EventRecorder
+	healthChecker *healthcheck.Manager
+
+	// conflictAttempts tracks consecutive port-conflict backoff attempts per
+	// Service, keyed by its NamespacedName. Absent entries are attempt zero.
+	conflictAttempts sync.Map
 }
 
 func NewServiceReconciler(
 	c client.Client, scheme *runtime.Scheme, recorder record.EventRecorder, graph *graph.ServiceGraph, config *internal.Config,
+	healthChecker *healthcheck.Manager,
 ) *ServiceReconciler {
 	return &ServiceReconciler{
-		Client:   c,
-		scheme:   scheme,
-		graph:    graph,
-		config:   config,
-		recorder: recorder,
+		Client:        c,
+		scheme:        scheme,
+		graph:         graph,
+		config:        config,
+		recorder:      recorder,
+		healthChecker: healthChecker,
 	}
 }
 
@@ -75,6 +134,7 @@ func NewServiceReconciler(
 //+kubebuilder:rbac:groups=core,resources=services/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 //+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+//+kubebuilder:rbac:groups=multicluster.x-k8s.io,resources=serviceimports,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -84,6 +144,8 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
 		if k8serrors.IsNotFound(err) {
 			r.graph.RemoveService(req.NamespacedName)
+			r.healthChecker.RemoveService(req.NamespacedName)
+			r.conflictAttempts.Delete(req.NamespacedName)
 		}
 
 		return ctrl.Result{}, client.IgnoreNotFound(err)
@@ -93,37 +155,141 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, nil
 	}
 
-	ports := r.getServicePorts(&svc)
-	for _, port := range ports {
-		if r.graph.Conflicts(req.NamespacedName, port) {
-			r.recorder.Eventf(&svc, "Warning", "Conflict", "Service could not be allocated due to a conflicting port %v", port.String())
+	ports, rangePorts := r.getServicePorts(&svc)
+	if conflictPort, owner, ok := r.conflictingPort(req.NamespacedName, ports, rangePorts); ok {
+		r.recorder.Eventf(&svc, "Warning", "Conflict", "port %s is already allocated to Service %s", conflictPort.String(), owner.String())
 
-			return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
-		}
+		return ctrl.Result{RequeueAfter: r.nextConflictBackoff(req.NamespacedName)}, nil
 	}
+	r.conflictAttempts.Delete(req.NamespacedName)
 
-	data, err := r.buildServiceData(ctx, &svc, ports)
+	data, err := r.buildServiceData(ctx, &svc, ports, rangePorts)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
 	r.graph.UpdateService(req.NamespacedName, data)
 
-	if err := r.updateStatus(ctx, &svc); err != nil {
+	if err := r.updateStatus(ctx, &svc, ports, rangePorts); err != nil {
 		return ctrl.Result{}, err
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// conflictingPort reports the first port in ports already owned by another
+// Service, if any. Consecutive same-protocol ports flagged in rangePorts are
+// checked with a single graph.ConflictOwnerRange query covering the whole
+// run instead of one graph.ConflictOwner query per port, so a wide
+// port-ranges block doesn't turn conflict detection into an O(width) scan.
+func (r *ServiceReconciler) conflictingPort(
+	name types.NamespacedName, ports []graph.ServicePort, rangePorts map[graph.ServicePort]bool,
+) (graph.ServicePort, types.NamespacedName, bool) {
+	for i := 0; i < len(ports); i++ {
+		port := ports[i]
+
+		if !rangePorts[port] {
+			if owner, ok := r.graph.ConflictOwner(name, port); ok {
+				return port, owner, true
+			}
+
+			continue
+		}
+
+		start := port
+		end := port
+		j := i + 1
+		for j < len(ports) && rangePorts[ports[j]] &&
+			ports[j].Protocol == start.Protocol && ports[j].Port == end.Port+1 {
+			end = ports[j]
+			j++
+		}
+
+		if owner, ok := r.graph.ConflictOwnerRange(name, start.Protocol, start.Port, end.Port); ok {
+			for _, p := range ports[i:j] {
+				if o, ok := r.graph.ConflictOwner(name, p); ok {
+					return p, o, true
+				}
+			}
+
+			return start, owner, true
+		}
+
+		i = j - 1
+	}
+
+	return graph.ServicePort{}, types.NamespacedName{}, false
+}
+
+// nextConflictBackoff returns the delay before req's next reconcile attempt
+// and advances its attempt counter, doubling the delay each consecutive
+// conflict up to conflictBackoffMax.
+func (r *ServiceReconciler) nextConflictBackoff(name types.NamespacedName) time.Duration {
+	attempt, _ := r.conflictAttempts.LoadOrStore(name, 0)
+	n, _ := attempt.(int)
+	r.conflictAttempts.Store(name, n+1)
+
+	delay := conflictBackoffBase << n
+	if delay <= 0 || delay > conflictBackoffMax {
+		delay = conflictBackoffMax
+	}
+
+	return delay
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Service{}).
 		Watches(&discoveryv1.EndpointSlice{}, handler.EnqueueRequestsFromMapFunc(r.findServiceForEndpoint)).
+		Watches(&discoveryv1.EndpointSlice{}, handler.EnqueueRequestsFromMapFunc(r.findServicesImportingFromEndpoint)).
+		Watches(&mcsv1alpha1.ServiceImport{}, handler.EnqueueRequestsFromMapFunc(r.findServicesImporting)).
 		Complete(r)
 }
 
+// findServicesImporting requeues every Service in serviceImport's namespace
+// that references it via importFromAnnotation, so changes fed into
+// graph.ServiceGraph by ServiceImportReconciler get folded into the owning
+// Service's EDS output.
+func (r *ServiceReconciler) findServicesImporting(ctx context.Context, serviceImport client.Object) []reconcile.Request {
+	return r.findServicesImportingByName(ctx, serviceImport.GetNamespace(), serviceImport.GetName())
+}
+
+// findServicesImportingFromEndpoint is the ServiceImport-side counterpart of
+// findServiceForEndpoint: mirrored EndpointSlices carry
+// multiClusterServiceNameLabel (the ServiceImport they back) rather than
+// discoveryv1.LabelServiceName, so a remote endpoint coming up or going down
+// wouldn't otherwise requeue any importing Service and its EDS output would
+// go stale until something else touched the ServiceImport object.
+func (r *ServiceReconciler) findServicesImportingFromEndpoint(ctx context.Context, endpointSlice client.Object) []reconcile.Request {
+	importName := endpointSlice.GetLabels()[multiClusterServiceNameLabel]
+	if importName == "" {
+		return nil
+	}
+
+	return r.findServicesImportingByName(ctx, endpointSlice.GetNamespace(), importName)
+}
+
+func (r *ServiceReconciler) findServicesImportingByName(ctx context.Context, namespace, importName string) []reconcile.Request {
+	var svcList corev1.ServiceList
+	if err := r.List(ctx, &svcList, client.InNamespace(namespace)); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, svc := range svcList.Items {
+		if svc.Annotations[importFromAnnotation] != importName {
+			continue
+		}
+
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace},
+		})
+	}
+
+	return requests
+}
+
 func (r *ServiceReconciler) buildEndpoints(ctx context.Context, svc *corev1.Service, port graph.ServicePort) ([]graph.ServiceEndpoint, error) {
 	var svcPort *corev1.ServicePort
 	for _, sp := range svc.Spec.Ports {
@@ -141,7 +307,11 @@ func (r *ServiceReconciler) buildEndpoints(ctx context.Context, svc *corev1.Serv
 		return nil, err
 	}
 
-	ips := make(map[netip.AddrPort]bool)
+	topologyAware := topologyAwareRouting(svc) && r.config.ControlPlaneZone != ""
+
+	ips := make(map[netip.AddrPort]graph.EndpointState)
+	zones := make(map[netip.AddrPort]string)
+	localZoneHinted := make(map[netip.AddrPort]bool)
 
 	for _, es := range esList.Items {
 		if !((es.AddressType == discoveryv1.AddressTypeIPv6 && r.config.UseIPv6Endpoints) ||
@@ -171,9 +341,21 @@ func (r *ServiceReconciler) buildEndpoints(ctx context.Context, svc *corev1.Serv
 		}
 
 		for _, ep := range es.Endpoints {
-			ready := true
-			if ep.Conditions.Ready != nil {
-				ready = *ep.Conditions.Ready
+			state := endpointState(ep, svc.Spec.PublishNotReadyAddresses)
+			if state == graph.EndpointNotServing {
+				continue
+			}
+
+			var zone string
+			if ep.Zone != nil {
+				zone = *ep.Zone
+			}
+			hinted := endpointHintedForZone(ep, r.config.ControlPlaneZone)
+
+			addIP := func(ap netip.AddrPort) {
+				ips[ap] = state
+				zones[ap] = zone
+				localZoneHinted[ap] = hinted
 			}
 
 			if r.config.AddressSource == internal.AddressSourceNode {
@@ -184,7 +366,7 @@ func (r *ServiceReconciler) buildEndpoints(ctx context.Context, svc *corev1.Serv
 						continue
 					}
 
-					ips[netip.AddrPortFrom(*ip, uint16(svcPort.NodePort))] = ready
+					addIP(netip.AddrPortFrom(*ip, uint16(svcPort.NodePort)))
 				}
 			} else {
 				for _, addr := range ep.Addresses {
@@ -193,16 +375,115 @@ func (r *ServiceReconciler) buildEndpoints(ctx context.Context, svc *corev1.Serv
 						return nil, err
 					}
 
-					ips[netip.AddrPortFrom(ip, uint16(*epPort.Port))] = ready
+					addIP(netip.AddrPortFrom(ip, uint16(*epPort.Port)))
 				}
 			}
 		}
 	}
 
+	if topologyAware {
+		filterToLocalZoneHints(ips, localZoneHinted)
+	}
+
+	var ipList []netip.AddrPort
+	for ip := range ips {
+		ipList = append(ipList, ip)
+	}
+
+	sort.Slice(ipList, func(i, j int) bool {
+		return ipList[i].Addr().Less(ipList[j].Addr())
+	})
+
+	svcKey := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+
+	hcCfg, hcEnabled, err := r.healthCheckConfig(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	if hcEnabled {
+		r.healthChecker.Sync(svcKey, port, hcCfg, ipList)
+		ipList = filterHealthy(r.healthChecker, svcKey, port, ipList)
+	} else {
+		r.healthChecker.Remove(svcKey, port)
+	}
+
+	var endpoints []graph.ServiceEndpoint
+
+	for _, ip := range ipList {
+		endpoints = append(endpoints, graph.ServiceEndpoint{
+			AddrPort:  ip,
+			Protocol:  port.Protocol,
+			State:     ips[ip],
+			Zone:      zones[ip],
+			ClusterID: r.config.ClusterID,
+		})
+	}
+
+	return endpoints, nil
+}
+
+// buildRangeEndpoints builds the endpoints for a ServicePort expanded from
+// the port-ranges annotation. Unlike buildEndpoints, there's no matching
+// Spec.Ports/targetPort entry to translate through: a range fronts a
+// hostPort/NodePort block on a DaemonSet one-to-one, so every port in the
+// range shares the same set of endpoint addresses and simply forwards to
+// port.Port on each of them directly.
+func (r *ServiceReconciler) buildRangeEndpoints(ctx context.Context, svc *corev1.Service, port graph.ServicePort) ([]graph.ServiceEndpoint, error) {
+	var esList discoveryv1.EndpointSliceList
+	if err := r.List(ctx, &esList, client.InNamespace(svc.Namespace), client.MatchingLabels{discoveryv1.LabelServiceName: svc.Name}); err != nil {
+		return nil, err
+	}
+
 	var ipList []netip.AddrPort
-	for ip, ready := range ips {
-		if ready {
-			ipList = append(ipList, ip)
+	states := make(map[netip.AddrPort]graph.EndpointState)
+	zones := make(map[netip.AddrPort]string)
+
+	for _, es := range esList.Items {
+		if !((es.AddressType == discoveryv1.AddressTypeIPv6 && r.config.UseIPv6Endpoints) ||
+			(es.AddressType == discoveryv1.AddressTypeIPv4 && !r.config.UseIPv6Endpoints)) {
+			continue
+		}
+
+		for _, ep := range es.Endpoints {
+			state := endpointState(ep, svc.Spec.PublishNotReadyAddresses)
+			if state == graph.EndpointNotServing {
+				continue
+			}
+
+			var zone string
+			if ep.Zone != nil {
+				zone = *ep.Zone
+			}
+
+			addIP := func(ip netip.Addr) {
+				ap := netip.AddrPortFrom(ip, uint16(port.Port))
+				states[ap] = state
+				zones[ap] = zone
+				ipList = append(ipList, ap)
+			}
+
+			if r.config.AddressSource == internal.AddressSourceNode {
+				if ep.NodeName == nil {
+					continue
+				}
+
+				ip, err := r.getNodeAddress(ctx, *ep.NodeName)
+				if err != nil {
+					continue
+				}
+
+				addIP(*ip)
+			} else {
+				for _, addr := range ep.Addresses {
+					ip, err := netip.ParseAddr(addr)
+					if err != nil {
+						return nil, err
+					}
+
+					addIP(ip)
+				}
+			}
 		}
 	}
 
@@ -210,34 +491,244 @@ func (r *ServiceReconciler) buildEndpoints(ctx context.Context, svc *corev1.Serv
 		return ipList[i].Addr().Less(ipList[j].Addr())
 	})
 
+	svcKey := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+
+	hcCfg, hcEnabled, err := r.healthCheckConfig(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	if hcEnabled {
+		r.healthChecker.Sync(svcKey, port, hcCfg, ipList)
+		ipList = filterHealthy(r.healthChecker, svcKey, port, ipList)
+	} else {
+		r.healthChecker.Remove(svcKey, port)
+	}
+
 	var endpoints []graph.ServiceEndpoint
 
 	for _, ip := range ipList {
 		endpoints = append(endpoints, graph.ServiceEndpoint{
-			AddrPort: ip,
-			Protocol: port.Protocol,
+			AddrPort:  ip,
+			Protocol:  port.Protocol,
+			State:     states[ip],
+			Zone:      zones[ip],
+			ClusterID: r.config.ClusterID,
 		})
 	}
 
 	return endpoints, nil
 }
 
-func (r *ServiceReconciler) buildServiceData(ctx context.Context, svc *corev1.Service, ports []graph.ServicePort) (*graph.ServiceData, error) {
-	var useProxyProtocol bool
-	if svc.Annotations[proxyProtocolAnnotation] == "true" {
-		useProxyProtocol = true
+// healthCheckConfig parses svc's healthcheck annotations into a
+// healthcheck.Config, reporting ok=false when the Service doesn't opt into
+// active health checking at all. Malformed values are reported via a Warning
+// event in addition to the returned error.
+func (r *ServiceReconciler) healthCheckConfig(svc *corev1.Service) (healthcheck.Config, bool, error) {
+	raw := svc.Annotations[healthCheckAnnotation]
+	if raw == "" {
+		return healthcheck.Config{}, false, nil
 	}
 
-	var idleTimeout *time.Duration
-	if svc.Annotations[idleTimeoutAnnotation] != "" {
-		dur, err := time.ParseDuration(svc.Annotations[idleTimeoutAnnotation])
+	var protocol healthcheck.Protocol
+	var httpPath string
+
+	switch {
+	case raw == "tcp":
+		protocol = healthcheck.ProtocolTCP
+	case raw == "grpc":
+		protocol = healthcheck.ProtocolGRPC
+	case strings.HasPrefix(raw, "http:"):
+		protocol = healthcheck.ProtocolHTTP
+		httpPath = strings.TrimPrefix(raw, "http:")
+	default:
+		r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", healthCheckAnnotation, raw)
+		return healthcheck.Config{}, false, fmt.Errorf("annotation %s has invalid value %q", healthCheckAnnotation, raw)
+	}
+
+	cfg := healthcheck.DefaultConfig(protocol, httpPath)
+
+	if v := svc.Annotations[healthCheckIntervalAnnotation]; v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", healthCheckIntervalAnnotation, v)
+			return healthcheck.Config{}, false, err
+		}
+		cfg.Interval = dur
+	}
+
+	if v := svc.Annotations[healthCheckTimeoutAnnotation]; v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", healthCheckTimeoutAnnotation, v)
+			return healthcheck.Config{}, false, err
+		}
+		cfg.Timeout = dur
+	}
+
+	if v := svc.Annotations[healthCheckUnhealthyThresholdAnnotation]; v != "" {
+		n, err := strconv.Atoi(v)
 		if err != nil {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", healthCheckUnhealthyThresholdAnnotation, v)
+			return healthcheck.Config{}, false, err
+		}
+		cfg.UnhealthyThreshold = n
+	}
+
+	if v := svc.Annotations[healthCheckHealthyThresholdAnnotation]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", healthCheckHealthyThresholdAnnotation, v)
+			return healthcheck.Config{}, false, err
+		}
+		cfg.HealthyThreshold = n
+	}
+
+	return cfg, true, nil
+}
+
+// filterHealthy restricts ips to the ones the health checker currently
+// considers healthy for (svc, port). Endpoints with no active probe (e.g.
+// health checking isn't enabled) always pass through.
+func filterHealthy(m *healthcheck.Manager, svc types.NamespacedName, port graph.ServicePort, ips []netip.AddrPort) []netip.AddrPort {
+	var out []netip.AddrPort
+	for _, ip := range ips {
+		if m.Healthy(svc, port, ip) {
+			out = append(out, ip)
+		}
+	}
+
+	return out
+}
+
+// topologyAwareRouting reports whether svc has opted into topology-aware
+// endpoint selection, via either the current or the deprecated annotation.
+func topologyAwareRouting(svc *corev1.Service) bool {
+	if svc.Annotations[topologyModeAnnotation] == topologyModeAuto {
+		return true
+	}
+
+	return svc.Annotations[topologyAwareHintsAnnotationOld] == topologyModeAutoDeprecated
+}
+
+// endpointHintedForZone reports whether ep's EndpointSlice zone hints
+// recommend routing to it from zone.
+func endpointHintedForZone(ep discoveryv1.Endpoint, zone string) bool {
+	if ep.Hints == nil {
+		return false
+	}
+
+	for _, forZone := range ep.Hints.ForZones {
+		if forZone.Name == zone {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterToLocalZoneHints restricts ips to the subset hinted for the local
+// zone, falling back to the full set (i.e. doing nothing) when the hinted
+// subset would otherwise be empty.
+func filterToLocalZoneHints(ips map[netip.AddrPort]graph.EndpointState, hinted map[netip.AddrPort]bool) {
+	var hasHinted bool
+	for _, h := range hinted {
+		if h {
+			hasHinted = true
+			break
+		}
+	}
+	if !hasHinted {
+		return
+	}
+
+	for ip := range ips {
+		if !hinted[ip] {
+			delete(ips, ip)
+		}
+	}
+}
+
+func (r *ServiceReconciler) buildServiceData(
+	ctx context.Context, svc *corev1.Service, ports []graph.ServicePort, rangePorts map[graph.ServicePort]bool,
+) (*graph.ServiceData, error) {
+	listener, err := r.buildListenerConfig(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	data := graph.NewServiceData()
+
+	var importFrom types.NamespacedName
+	if name := svc.Annotations[importFromAnnotation]; name != "" {
+		importFrom = types.NamespacedName{Namespace: svc.Namespace, Name: name}
+	}
+
+	topologyAware := topologyAwareRouting(svc) && r.config.ControlPlaneZone != ""
+
+	for _, port := range ports {
+		var endpoints []graph.ServiceEndpoint
+		var err error
+
+		if rangePorts[port] {
+			endpoints, err = r.buildRangeEndpoints(ctx, svc, port)
+		} else {
+			endpoints, err = r.buildEndpoints(ctx, svc, port)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if importFrom.Name != "" {
+			endpoints = append(endpoints, r.graph.ImportedEndpoints(importFrom, port)...)
+		}
+
+		data.Ports[port] = graph.ServicePortData{
+			Endpoints:     endpoints,
+			Listener:      *listener,
+			TopologyAware: topologyAware,
+		}
+	}
+
+	return data, nil
+}
+
+// buildListenerConfig translates a Service's annotations into the typed
+// graph.ListenerConfig the xDS generator assembles the filter chain from.
+// Malformed annotation values are reported via a Warning event on svc in
+// addition to the returned error, so operators see the problem without
+// having to correlate controller logs back to the Service.
+//
+// TODO(chunk0-1): the original request for graceful draining also asked for
+// a per-Service xds-servicelb.eplight.org/termination-grace-period
+// annotation overriding Envoy's drain duration. It was intentionally left
+// unimplemented (see d32e393) because internal/xds only generates EDS today
+// and has no CDS/LDS layer to apply a drain duration to; wire it in once
+// that consumer exists instead of threading an annotation nothing reads.
+func (r *ServiceReconciler) buildListenerConfig(svc *corev1.Service) (*graph.ListenerConfig, error) {
+	cfg := &graph.ListenerConfig{
+		Protocol:         graph.ListenerProtocolTCP,
+		UseProxyProtocol: svc.Annotations[proxyProtocolAnnotation] == "true",
+	}
+
+	if raw := svc.Annotations[protocolAnnotation]; raw != "" {
+		protocol, ok := listenerProtocolFromString(raw)
+		if !ok {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", protocolAnnotation, raw)
+			return nil, fmt.Errorf("annotation %s has invalid value %q", protocolAnnotation, raw)
+		}
+		cfg.Protocol = protocol
+	}
+
+	if raw := svc.Annotations[idleTimeoutAnnotation]; raw != "" {
+		dur, err := time.ParseDuration(raw)
+		if err != nil {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", idleTimeoutAnnotation, raw)
 			return nil, err
 		}
-		idleTimeout = &dur
+		cfg.IdleTimeout = &dur
 	}
 
-	var allowedIPRanges []netip.Prefix
 	if len(svc.Spec.LoadBalancerSourceRanges) > 0 {
 		for _, ip := range svc.Spec.LoadBalancerSourceRanges {
 			prefix, err := netip.ParsePrefix(ip)
@@ -245,27 +736,67 @@ func (r *ServiceReconciler) buildServiceData(ctx context.Context, svc *corev1.Se
 				return nil, err
 			}
 
-			allowedIPRanges = append(allowedIPRanges, prefix)
+			cfg.AllowedIPRanges = append(cfg.AllowedIPRanges, prefix)
 		}
 	}
 
-	data := graph.NewServiceData()
+	if raw := svc.Annotations[tlsSNIRoutesAnnotation]; raw != "" {
+		var routes []graph.SNIRoute
+		if err := json.Unmarshal([]byte(raw), &routes); err != nil {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s is not valid JSON: %v", tlsSNIRoutesAnnotation, err)
+			return nil, fmt.Errorf("annotation %s is not valid JSON: %w", tlsSNIRoutesAnnotation, err)
+		}
+		cfg.SNIRoutes = routes
+	}
 
-	for _, port := range ports {
-		endpoints, err := r.buildEndpoints(ctx, svc, port)
+	cfg.HTTPAccessLogPath = svc.Annotations[httpAccessLogAnnotation]
+
+	if raw := svc.Annotations[connectionLimitAnnotation]; raw != "" {
+		limit, err := strconv.ParseUint(raw, 10, 32)
 		if err != nil {
-			return nil, err
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s has invalid value %q", connectionLimitAnnotation, raw)
+			return nil, fmt.Errorf("annotation %s has invalid value %q: %w", connectionLimitAnnotation, raw, err)
 		}
+		limit32 := uint32(limit)
+		cfg.ConnectionLimit = &limit32
+	}
 
-		data.Ports[port] = graph.ServicePortData{
-			Endpoints:        endpoints,
-			UseProxyProtocol: useProxyProtocol,
-			IdleTimeout:      idleTimeout,
-			AllowedIPRanges:  allowedIPRanges,
-		}
+	return cfg, nil
+}
+
+func listenerProtocolFromString(s string) (graph.ListenerProtocol, bool) {
+	switch graph.ListenerProtocol(s) {
+	case graph.ListenerProtocolTCP, graph.ListenerProtocolHTTP, graph.ListenerProtocolHTTP2,
+		graph.ListenerProtocolGRPC, graph.ListenerProtocolTLSPassthrough:
+		return graph.ListenerProtocol(s), true
+	default:
+		return "", false
 	}
+}
 
-	return data, nil
+// endpointState classifies ep following kube-proxy's Ready/Serving/Terminating
+// semantics: ready endpoints always serve, serving-but-terminating endpoints
+// are kept around (marked as draining in xDS) so existing connections can drain, and
+// everything else is excluded. Services that opt into
+// PublishNotReadyAddresses want every endpoint treated as ready regardless of
+// its conditions.
+func endpointState(ep discoveryv1.Endpoint, publishNotReadyAddresses bool) graph.EndpointState {
+	if publishNotReadyAddresses {
+		return graph.EndpointReady
+	}
+
+	ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+	if ready {
+		return graph.EndpointReady
+	}
+
+	serving := ep.Conditions.Serving != nil && *ep.Conditions.Serving
+	terminating := ep.Conditions.Terminating != nil && *ep.Conditions.Terminating
+	if serving && terminating {
+		return graph.EndpointServingTerminating
+	}
+
+	return graph.EndpointNotServing
 }
 
 func (r *ServiceReconciler) findServiceForEndpoint(ctx context.Context, endpointSlice client.Object) []reconcile.Request {
@@ -310,24 +841,111 @@ func (r *ServiceReconciler) getNodeAddress(ctx context.Context, nodeName string)
 	return nil, noValidNodeAddressError
 }
 
-func (r *ServiceReconciler) getServicePorts(svc *corev1.Service) []graph.ServicePort {
+// getServicePorts returns every graph.ServicePort svc exposes: the discrete
+// ports from Spec.Ports plus any expanded from the port-ranges annotation.
+// The second return value flags which of those ports came from the
+// annotation, since they share a single pool of upstream endpoints instead of
+// being matched against a Spec.Ports entry (see buildRangeEndpoints).
+func (r *ServiceReconciler) getServicePorts(svc *corev1.Service) ([]graph.ServicePort, map[graph.ServicePort]bool) {
 	var ports []graph.ServicePort
 
 	for _, port := range svc.Spec.Ports {
-		if port.Protocol == corev1.ProtocolTCP {
-			ports = append(ports, graph.ServicePort{
-				Port:     port.Port,
-				Protocol: net.TCP,
-			})
-		} else if port.Protocol == corev1.ProtocolUDP {
-			ports = append(ports, graph.ServicePort{
-				Port:     port.Port,
-				Protocol: net.UDP,
-			})
+		protocol, ok := graphProtocol(port.Protocol)
+		if !ok {
+			continue
+		}
+
+		ports = append(ports, graph.ServicePort{
+			Port:     port.Port,
+			Protocol: protocol,
+		})
+	}
+
+	rangePorts := make(map[graph.ServicePort]bool)
+
+	if raw := svc.Annotations[portRangesAnnotation]; raw != "" {
+		parsed, err := parsePortRanges(raw)
+		if err != nil {
+			r.recorder.Eventf(svc, "Warning", "InvalidAnnotation", "annotation %s: %v", portRangesAnnotation, err)
+		} else {
+			for _, port := range parsed {
+				rangePorts[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+
+	return ports, rangePorts
+}
+
+// parsePortRanges parses the port-ranges annotation value, e.g.
+// "10000-10100/udp,20000-20050/tcp", into the ServicePort entries it expands
+// to.
+func parsePortRanges(raw string) ([]graph.ServicePort, error) {
+	var ports []graph.ServicePort
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rangeAndProtocol := strings.SplitN(entry, "/", 2)
+		if len(rangeAndProtocol) != 2 {
+			return nil, fmt.Errorf("entry %q: expected <start>-<end>/<protocol>", entry)
+		}
+
+		protocol, ok := graphProtocol(corev1.Protocol(strings.ToUpper(rangeAndProtocol[1])))
+		if !ok {
+			return nil, fmt.Errorf("entry %q: unsupported protocol %q", entry, rangeAndProtocol[1])
+		}
+
+		startEnd := strings.SplitN(rangeAndProtocol[0], "-", 2)
+		if len(startEnd) != 2 {
+			return nil, fmt.Errorf("entry %q: expected <start>-<end>/<protocol>", entry)
+		}
+
+		start, err := strconv.ParseInt(startEnd[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: invalid start port: %w", entry, err)
+		}
+
+		end, err := strconv.ParseInt(startEnd[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: invalid end port: %w", entry, err)
+		}
+
+		if end < start {
+			return nil, fmt.Errorf("entry %q: end port before start port", entry)
+		}
+
+		if start < minPort || end > maxPort {
+			return nil, fmt.Errorf("entry %q: ports must be in range %d-%d", entry, minPort, maxPort)
+		}
+
+		if end-start+1 > maxPortRangeWidth {
+			return nil, fmt.Errorf("entry %q: range exceeds maximum width of %d ports", entry, maxPortRangeWidth)
+		}
+
+		for port := start; port <= end; port++ {
+			ports = append(ports, graph.ServicePort{Port: int32(port), Protocol: protocol})
 		}
 	}
 
-	return ports
+	return ports, nil
+}
+
+// graphProtocol maps a core/v1 Protocol onto the graph package's
+// representation, reporting false for anything other than TCP/UDP.
+func graphProtocol(protocol corev1.Protocol) (net.Protocol, bool) {
+	switch protocol {
+	case corev1.ProtocolTCP:
+		return net.TCP, true
+	case corev1.ProtocolUDP:
+		return net.UDP, true
+	default:
+		return "", false
+	}
 }
 
 func (r *ServiceReconciler) shouldManage(svc *corev1.Service) bool {
@@ -347,21 +965,65 @@ func (r *ServiceReconciler) shouldManage(svc *corev1.Service) bool {
 	return true
 }
 
-func (r *ServiceReconciler) updateStatus(ctx context.Context, svc *corev1.Service) error {
+func (r *ServiceReconciler) updateStatus(
+	ctx context.Context, svc *corev1.Service, ports []graph.ServicePort, rangePorts map[graph.ServicePort]bool,
+) error {
+	portStatuses := summarizePortStatuses(ports, rangePorts)
+
 	var ing []corev1.LoadBalancerIngress
 	for _, addr := range r.config.IngressStatus {
+		entry := corev1.LoadBalancerIngress{Ports: portStatuses}
+
 		if addr.IP != nil {
-			ing = append(ing, corev1.LoadBalancerIngress{
-				IP: addr.IP.String(),
-			})
+			entry.IP = addr.IP.String()
 		} else {
-			ing = append(ing, corev1.LoadBalancerIngress{
-				Hostname: addr.Hostname,
-			})
+			entry.Hostname = addr.Hostname
 		}
+
+		ing = append(ing, entry)
 	}
 
 	svc.Status.LoadBalancer.Ingress = ing
 
 	return r.Status().Update(ctx, svc)
 }
+
+// summarizePortStatuses builds the Status.LoadBalancer.Ingress[].Ports entries
+// for ports. corev1.PortStatus has no way to express a range, and enumerating
+// one entry per port for a wide port-ranges block would bloat Service status
+// to thousands of entries on every reconcile; instead, each contiguous run of
+// rangePorts is collapsed into a single entry for the run's first port, with
+// the full range still discoverable via the port-ranges annotation itself.
+// Ports outside rangePorts (Spec.Ports) are reported individually as before.
+func summarizePortStatuses(ports []graph.ServicePort, rangePorts map[graph.ServicePort]bool) []corev1.PortStatus {
+	var portStatuses []corev1.PortStatus
+
+	for i := 0; i < len(ports); i++ {
+		port := ports[i]
+
+		if !rangePorts[port] {
+			portStatuses = append(portStatuses, corev1.PortStatus{
+				Port:     port.Port,
+				Protocol: corev1.Protocol(port.Protocol),
+			})
+			continue
+		}
+
+		portStatuses = append(portStatuses, corev1.PortStatus{
+			Port:     port.Port,
+			Protocol: corev1.Protocol(port.Protocol),
+		})
+
+		end := port
+		j := i + 1
+		for j < len(ports) && rangePorts[ports[j]] &&
+			ports[j].Protocol == port.Protocol && ports[j].Port == end.Port+1 {
+			end = ports[j]
+			j++
+		}
+
+		i = j - 1
+	}
+
+	return portStatuses
+}
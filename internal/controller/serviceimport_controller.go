@@ -0,0 +1,185 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/eplightning/xds-servicelb/internal"
+	"github.com/eplightning/xds-servicelb/internal/graph"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/net"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// ServiceImportReconciler mirrors the endpoints of an MCS ServiceImport into
+// graph.ServiceGraph, so they can be unioned into the EDS output of any local
+// Service that references the import via importFromAnnotation.
+type ServiceImportReconciler struct {
+	client.Client
+	scheme *runtime.Scheme
+	graph  *graph.ServiceGraph
+	config *internal.Config
+}
+
+func NewServiceImportReconciler(
+	c client.Client, scheme *runtime.Scheme, graph *graph.ServiceGraph, config *internal.Config,
+) *ServiceImportReconciler {
+	return &ServiceImportReconciler{
+		Client: c,
+		scheme: scheme,
+		graph:  graph,
+		config: config,
+	}
+}
+
+//+kubebuilder:rbac:groups=multicluster.x-k8s.io,resources=serviceimports,verbs=get;list;watch
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+
+func (r *ServiceImportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	var svcImport mcsv1alpha1.ServiceImport
+	if err := r.Get(ctx, req.NamespacedName, &svcImport); err != nil {
+		if k8serrors.IsNotFound(err) {
+			r.graph.RemoveImport(req.NamespacedName)
+		}
+
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	ports, err := r.buildImportedPorts(ctx, &svcImport)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.graph.UpdateImport(req.NamespacedName, ports)
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceImportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&mcsv1alpha1.ServiceImport{}).
+		Watches(&discoveryv1.EndpointSlice{}, handler.EnqueueRequestsFromMapFunc(r.findServiceImportForEndpoint)).
+		Complete(r)
+}
+
+func (r *ServiceImportReconciler) buildImportedPorts(ctx context.Context, svcImport *mcsv1alpha1.ServiceImport) (map[graph.ServicePort][]graph.ServiceEndpoint, error) {
+	var esList discoveryv1.EndpointSliceList
+	if err := r.List(ctx, &esList, client.InNamespace(svcImport.Namespace),
+		client.MatchingLabels{multiClusterServiceNameLabel: svcImport.Name}); err != nil {
+		return nil, err
+	}
+
+	ports := make(map[graph.ServicePort][]graph.ServiceEndpoint)
+
+	for _, importPort := range svcImport.Spec.Ports {
+		protocol, ok := graphProtocol(importPort.Protocol)
+		if !ok {
+			continue
+		}
+
+		port := graph.ServicePort{
+			Port:     importPort.Port,
+			Protocol: protocol,
+		}
+
+		ports[port] = r.buildImportedEndpoints(esList.Items, importPort, protocol)
+	}
+
+	return ports, nil
+}
+
+func (r *ServiceImportReconciler) buildImportedEndpoints(slices []discoveryv1.EndpointSlice, importPort mcsv1alpha1.ServicePort, protocol net.Protocol) []graph.ServiceEndpoint {
+	var endpoints []graph.ServiceEndpoint
+
+	for _, es := range slices {
+		if !((es.AddressType == discoveryv1.AddressTypeIPv6 && r.config.UseIPv6Endpoints) ||
+			(es.AddressType == discoveryv1.AddressTypeIPv4 && !r.config.UseIPv6Endpoints)) {
+			continue
+		}
+
+		clusterID := es.Labels[sourceClusterLabel]
+
+		var slicePort *int32
+		for _, epp := range es.Ports {
+			if epp.Port != nil && (epp.Name == nil || *epp.Name == importPort.Name) {
+				slicePort = epp.Port
+				break
+			}
+		}
+		if slicePort == nil {
+			continue
+		}
+
+		for _, ep := range es.Endpoints {
+			state := endpointState(ep, false)
+			if state == graph.EndpointNotServing {
+				continue
+			}
+
+			var zone string
+			if ep.Zone != nil {
+				zone = *ep.Zone
+			}
+
+			for _, addr := range ep.Addresses {
+				ip, err := netip.ParseAddr(addr)
+				if err != nil {
+					continue
+				}
+
+				endpoints = append(endpoints, graph.ServiceEndpoint{
+					AddrPort:  netip.AddrPortFrom(ip, uint16(*slicePort)),
+					Protocol:  protocol,
+					State:     state,
+					Zone:      zone,
+					ClusterID: clusterID,
+				})
+			}
+		}
+	}
+
+	return endpoints
+}
+
+func (r *ServiceImportReconciler) findServiceImportForEndpoint(ctx context.Context, endpointSlice client.Object) []reconcile.Request {
+	importName := endpointSlice.GetLabels()[multiClusterServiceNameLabel]
+	if importName == "" {
+		return nil
+	}
+
+	return []reconcile.Request{
+		{
+			NamespacedName: types.NamespacedName{
+				Name:      importName,
+				Namespace: endpointSlice.GetNamespace(),
+			},
+		},
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package graph holds the in-memory representation of every managed Service
+// that the xDS snapshot is generated from. It is the single piece of shared
+// state between the Service/EndpointSlice reconcilers and the xDS server.
+package graph
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/net"
+)
+
+// ServiceGraph is the shared, concurrency-safe store of ServiceData for every
+// Service currently managed by this controller instance.
+type ServiceGraph struct {
+	mu sync.RWMutex
+
+	services map[types.NamespacedName]*ServiceData
+	// owners is keyed by protocol so a Service claiming a wide
+	// port-ranges block (potentially thousands of ports) is tracked as a
+	// handful of intervals rather than one map entry per port.
+	owners map[net.Protocol]*portIntervalSet
+
+	// imports holds endpoints contributed by remote clusters through a
+	// ServiceImport, keyed by the ServiceImport's name and then by port. It
+	// is populated by ServiceImportReconciler and consumed by
+	// ServiceReconciler for Services carrying the import-from annotation.
+	imports map[types.NamespacedName]map[ServicePort][]ServiceEndpoint
+}
+
+func NewServiceGraph() *ServiceGraph {
+	return &ServiceGraph{
+		services: make(map[types.NamespacedName]*ServiceData),
+		owners:   make(map[net.Protocol]*portIntervalSet),
+		imports:  make(map[types.NamespacedName]map[ServicePort][]ServiceEndpoint),
+	}
+}
+
+// Conflicts reports whether port is already owned by a Service other than
+// name.
+func (g *ServiceGraph) Conflicts(name types.NamespacedName, port ServicePort) bool {
+	_, ok := g.ConflictOwner(name, port)
+	return ok
+}
+
+// ConflictOwner reports the Service that already owns port, if it isn't name,
+// so callers can name the culprit in diagnostics.
+func (g *ServiceGraph) ConflictOwner(name types.NamespacedName, port ServicePort) (types.NamespacedName, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	set, ok := g.owners[port.Protocol]
+	if !ok {
+		return types.NamespacedName{}, false
+	}
+
+	owner, ok := set.owner(port.Port)
+	if !ok || owner == name {
+		return types.NamespacedName{}, false
+	}
+
+	return owner, true
+}
+
+// ConflictOwnerRange reports the Service that already owns any port in
+// [start, end] for protocol, if it isn't name. It's the range counterpart of
+// ConflictOwner for checking a whole contiguous port-ranges block in one
+// interval-set query instead of one query per port in the range.
+func (g *ServiceGraph) ConflictOwnerRange(name types.NamespacedName, protocol net.Protocol, start, end int32) (types.NamespacedName, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	set, ok := g.owners[protocol]
+	if !ok {
+		return types.NamespacedName{}, false
+	}
+
+	return set.ownerInRange(name, start, end)
+}
+
+// UpdateService replaces the ServiceData for name and claims ownership of its
+// ports.
+func (g *ServiceGraph) UpdateService(name types.NamespacedName, data *ServiceData) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.releasePortsLocked(name)
+
+	g.services[name] = data
+
+	byProtocol := make(map[net.Protocol][]int32)
+	for port := range data.Ports {
+		byProtocol[port.Protocol] = append(byProtocol[port.Protocol], port.Port)
+	}
+
+	for protocol, ports := range byProtocol {
+		set, ok := g.owners[protocol]
+		if !ok {
+			set = &portIntervalSet{}
+			g.owners[protocol] = set
+		}
+
+		set.claim(name, ports)
+	}
+}
+
+// RemoveService drops name and releases any ports it owned.
+func (g *ServiceGraph) RemoveService(name types.NamespacedName) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.releasePortsLocked(name)
+	delete(g.services, name)
+}
+
+// Services returns a snapshot of the currently managed Services.
+func (g *ServiceGraph) Services() map[types.NamespacedName]*ServiceData {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	out := make(map[types.NamespacedName]*ServiceData, len(g.services))
+	for k, v := range g.services {
+		out[k] = v
+	}
+
+	return out
+}
+
+// UpdateImport replaces the endpoints contributed by the ServiceImport named
+// importName.
+func (g *ServiceGraph) UpdateImport(importName types.NamespacedName, ports map[ServicePort][]ServiceEndpoint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.imports[importName] = ports
+}
+
+// RemoveImport drops every endpoint previously contributed by importName.
+func (g *ServiceGraph) RemoveImport(importName types.NamespacedName) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.imports, importName)
+}
+
+// ImportedEndpoints returns the endpoints contributed by importName for port,
+// or nil if that ServiceImport isn't known or doesn't expose the port.
+func (g *ServiceGraph) ImportedEndpoints(importName types.NamespacedName, port ServicePort) []ServiceEndpoint {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.imports[importName][port]
+}
+
+func (g *ServiceGraph) releasePortsLocked(name types.NamespacedName) {
+	if _, ok := g.services[name]; !ok {
+		return
+	}
+
+	for _, set := range g.owners {
+		set.release(name)
+	}
+}
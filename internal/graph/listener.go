@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"net/netip"
+	"time"
+)
+
+// ListenerProtocol selects the Envoy filter chain a ServicePort's listener is
+// assembled from.
+type ListenerProtocol string
+
+const (
+	// ListenerProtocolTCP proxies raw TCP/UDP, the default.
+	ListenerProtocolTCP ListenerProtocol = "tcp"
+	// ListenerProtocolHTTP terminates HTTP/1.1.
+	ListenerProtocolHTTP ListenerProtocol = "http"
+	// ListenerProtocolHTTP2 terminates cleartext or TLS HTTP/2.
+	ListenerProtocolHTTP2 ListenerProtocol = "http2"
+	// ListenerProtocolGRPC terminates gRPC (HTTP/2 with the gRPC codec).
+	ListenerProtocolGRPC ListenerProtocol = "grpc"
+	// ListenerProtocolTLSPassthrough inspects SNI via the tls_inspector
+	// filter and routes to a cluster using sni_cluster, without terminating
+	// TLS.
+	ListenerProtocolTLSPassthrough ListenerProtocol = "tls-passthrough"
+)
+
+// SNIRoute maps a TLS SNI value to the upstream cluster a tls-passthrough
+// listener should route it to.
+type SNIRoute struct {
+	SNI     string
+	Cluster string
+}
+
+// ListenerConfig is the typed representation of everything a Service's
+// annotations can tweak about the listener/filter chain serving a
+// ServicePort.
+type ListenerConfig struct {
+	Protocol ListenerProtocol
+
+	UseProxyProtocol bool
+	IdleTimeout      *time.Duration
+	AllowedIPRanges  []netip.Prefix
+
+	// SNIRoutes is only consulted when Protocol is ListenerProtocolTLSPassthrough.
+	SNIRoutes []SNIRoute
+
+	// HTTPAccessLogPath, when set, enables the HTTP connection manager's
+	// file access log at this path. Only meaningful for the HTTP family of
+	// protocols.
+	HTTPAccessLogPath string
+
+	// ConnectionLimit, when set, caps concurrent connections via
+	// envoy.filters.network.connection_limit.
+	ConnectionLimit *uint32
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// portInterval is an inclusive [start, end] port range claimed by owner.
+type portInterval struct {
+	start, end int32
+	owner      types.NamespacedName
+}
+
+// portIntervalSet is a start-ordered, non-overlapping-across-owners set of
+// portInterval for a single protocol. It lets ServiceGraph answer "who owns
+// this port" in O(log n) via binary search rather than scanning every
+// individually allocated port, which matters once a Service claims a large
+// xds-servicelb.eplight.org/port-ranges block.
+type portIntervalSet struct {
+	intervals []portInterval
+}
+
+// owner returns who currently holds port, if anyone.
+func (s *portIntervalSet) owner(port int32) (types.NamespacedName, bool) {
+	i := sort.Search(len(s.intervals), func(i int) bool { return s.intervals[i].end >= port })
+	if i < len(s.intervals) && s.intervals[i].start <= port {
+		return s.intervals[i].owner, true
+	}
+
+	return types.NamespacedName{}, false
+}
+
+// ownerInRange returns the first owner other than name holding any port in
+// [start, end], if any. It does a single binary search to the first interval
+// that could overlap the range and then scans forward only over intervals
+// that actually overlap it, so a caller checking a whole port-ranges block
+// for conflicts does one ownerInRange call instead of one owner call per
+// port in the range.
+func (s *portIntervalSet) ownerInRange(name types.NamespacedName, start, end int32) (types.NamespacedName, bool) {
+	i := sort.Search(len(s.intervals), func(i int) bool { return s.intervals[i].end >= start })
+
+	for ; i < len(s.intervals) && s.intervals[i].start <= end; i++ {
+		if s.intervals[i].owner != name {
+			return s.intervals[i].owner, true
+		}
+	}
+
+	return types.NamespacedName{}, false
+}
+
+// release drops every interval owned by name.
+func (s *portIntervalSet) release(name types.NamespacedName) {
+	kept := s.intervals[:0]
+
+	for _, iv := range s.intervals {
+		if iv.owner != name {
+			kept = append(kept, iv)
+		}
+	}
+
+	s.intervals = kept
+}
+
+// claim replaces name's previously held intervals in this set with the ones
+// derived from ports, coalescing adjacent/contiguous ports into ranges so a
+// large claimed range stays a single interval instead of one per port.
+func (s *portIntervalSet) claim(name types.NamespacedName, ports []int32) {
+	s.release(name)
+
+	if len(ports) == 0 {
+		return
+	}
+
+	sorted := append([]int32(nil), ports...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	start, prev := sorted[0], sorted[0]
+	for _, p := range sorted[1:] {
+		if p == prev || p == prev+1 {
+			prev = p
+			continue
+		}
+
+		s.intervals = append(s.intervals, portInterval{start: start, end: prev, owner: name})
+		start, prev = p, p
+	}
+
+	s.intervals = append(s.intervals, portInterval{start: start, end: prev, owner: name})
+
+	sort.Slice(s.intervals, func(i, j int) bool { return s.intervals[i].start < s.intervals[j].start })
+}
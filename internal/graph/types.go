@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"net/netip"
+	"strconv"
+
+	"k8s.io/utils/net"
+)
+
+// ServicePort identifies a single Port/Protocol pair a Service is exposed on.
+type ServicePort struct {
+	Port     int32
+	Protocol net.Protocol
+}
+
+func (p ServicePort) String() string {
+	return p.Protocol.String() + "/" + strconv.FormatInt(int64(p.Port), 10)
+}
+
+// EndpointState classifies an endpoint for the purposes of load balancing and
+// connection draining, mirroring kube-proxy's Ready/Serving/Terminating handling.
+type EndpointState int
+
+const (
+	// EndpointReady endpoints receive normal traffic.
+	EndpointReady EndpointState = iota
+	// EndpointServingTerminating endpoints are still serving but their pod is
+	// terminating; they're marked DRAINING in xDS so Envoy stops sending new
+	// traffic to them while letting existing connections finish instead of
+	// being cut off.
+	EndpointServingTerminating
+	// EndpointNotServing endpoints must not receive any traffic.
+	EndpointNotServing
+)
+
+// ServiceEndpoint is a single upstream address backing a ServicePort.
+type ServiceEndpoint struct {
+	AddrPort netip.AddrPort
+	Protocol net.Protocol
+	State    EndpointState
+	// Zone is the topology zone the endpoint was scheduled in, taken from
+	// EndpointSlice's Endpoints[].Zone. Empty when the cluster doesn't
+	// publish zone information.
+	Zone string
+	// ClusterID identifies the member cluster the endpoint originates from.
+	// Set to the controller's configured ClusterID for endpoints discovered
+	// locally, and to the source-cluster label of the mirrored EndpointSlice
+	// (empty when the label is absent) for endpoints imported through a
+	// ServiceImport.
+	ClusterID string
+}
+
+// ServicePortData is everything the xDS generator needs to know about a single
+// ServicePort in order to build the corresponding Envoy listener/cluster.
+type ServicePortData struct {
+	Endpoints []ServiceEndpoint
+	Listener  ListenerConfig
+	// TopologyAware mirrors the Service's opt-in to topology-aware routing
+	// (service.kubernetes.io/topology-mode: Auto or the deprecated
+	// service.kubernetes.io/topology-aware-hints: auto, plus a configured
+	// control-plane zone). Only when set should the xDS generator group
+	// Endpoints into zone-prioritized localities; otherwise every endpoint
+	// belongs in a single priority-0 locality regardless of Zone.
+	TopologyAware bool
+}
+
+// ServiceData is the graph representation of everything a Service contributes
+// to the xDS snapshot, keyed by ServicePort.
+type ServiceData struct {
+	Ports map[ServicePort]ServicePortData
+}
+
+func NewServiceData() *ServiceData {
+	return &ServiceData{
+		Ports: make(map[ServicePort]ServicePortData),
+	}
+}
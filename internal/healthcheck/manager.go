@@ -0,0 +1,272 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package healthcheck implements an optional active health-checking
+// subsystem. It complements the passive kube Ready/Serving signal (which only
+// reflects what the pod's own readiness probe can see) by probing each
+// endpoint directly from the controller node, catching L4/L7 reachability
+// problems the pod itself can't observe.
+package healthcheck
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/eplightning/xds-servicelb/internal/graph"
+)
+
+// Defaults mirror kube-proxy/Envoy conventions: a handful of consecutive
+// failures/successes before flipping state, checked roughly every few
+// seconds.
+const (
+	DefaultInterval           = 10 * time.Second
+	DefaultTimeout            = 5 * time.Second
+	DefaultUnhealthyThreshold = 3
+	DefaultHealthyThreshold   = 2
+
+	// DefaultMaxConcurrentProbes bounds how many probes the worker pool runs
+	// at once, regardless of how many endpoints are being watched.
+	DefaultMaxConcurrentProbes = 32
+)
+
+// Config tunes a single Service/ServicePort's probing.
+type Config struct {
+	Protocol           Protocol
+	HTTPPath           string
+	Interval           time.Duration
+	Timeout            time.Duration
+	UnhealthyThreshold int
+	HealthyThreshold   int
+}
+
+// DefaultConfig returns the tunables used when a Service's healthcheck
+// annotations don't override them.
+func DefaultConfig(protocol Protocol, httpPath string) Config {
+	return Config{
+		Protocol:           protocol,
+		HTTPPath:           httpPath,
+		Interval:           DefaultInterval,
+		Timeout:            DefaultTimeout,
+		UnhealthyThreshold: DefaultUnhealthyThreshold,
+		HealthyThreshold:   DefaultHealthyThreshold,
+	}
+}
+
+type portKey struct {
+	service types.NamespacedName
+	port    graph.ServicePort
+}
+
+type target struct {
+	cfg     atomic.Pointer[Config]
+	cancel  context.CancelFunc
+	healthy *atomic.Bool
+}
+
+// Manager owns the set of actively probed endpoints and their current
+// eject/unject state. It is safe for concurrent use.
+type Manager struct {
+	mu      sync.Mutex
+	targets map[portKey]map[netip.AddrPort]*target
+	sem     chan struct{}
+}
+
+// NewManager creates a Manager whose probes share a worker pool bounded to
+// maxConcurrentProbes in-flight probes at any time.
+func NewManager(maxConcurrentProbes int) *Manager {
+	if maxConcurrentProbes <= 0 {
+		maxConcurrentProbes = DefaultMaxConcurrentProbes
+	}
+
+	return &Manager{
+		targets: make(map[portKey]map[netip.AddrPort]*target),
+		sem:     make(chan struct{}, maxConcurrentProbes),
+	}
+}
+
+// Rehydrate is the restart hook a freshly constructed Manager is expected to
+// call before it starts handling Syncs. It is currently a deliberate no-op,
+// not a stub: graph.ServiceGraph carries endpoint and listener state but not
+// the healthcheck.Config a port was probed with (Interval/Protocol/HTTPPath/
+// thresholds), since that's derived from Service annotations by the
+// reconciler rather than stored on the graph. There's nothing in g a Manager
+// could rebuild a target from.
+//
+// This is safe because Healthy reports true for any (svc, port, addr) it
+// hasn't started a prober for yet, so a restarted controller doesn't eject
+// endpoints during the gap between process start and the first Sync for each
+// Service - the reconciler's initial List/Watch redelivers every Service and
+// re-establishes real probers within its first reconcile pass. If Managers
+// ever need to survive a restart without that initial grace window (e.g. to
+// preserve eject state across a restart instead of defaulting healthy),
+// healthcheck.Config will need to become part of graph.ServiceGraph so there
+// is durable state for g to carry.
+func (m *Manager) Rehydrate(g *graph.ServiceGraph) {
+	_ = g
+}
+
+// Sync reconciles the actively probed endpoints for (svc, port) against
+// candidates, starting probers for newly seen endpoints, stopping ones for
+// endpoints that disappeared, and refreshing cfg for the ones that remain.
+func (m *Manager) Sync(svc types.NamespacedName, port graph.ServicePort, cfg Config, candidates []netip.AddrPort) {
+	key := portKey{service: svc, port: port}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.targets[key]
+	if !ok {
+		existing = make(map[netip.AddrPort]*target)
+		m.targets[key] = existing
+	}
+
+	wanted := make(map[netip.AddrPort]bool, len(candidates))
+	for _, addr := range candidates {
+		wanted[addr] = true
+
+		if t, ok := existing[addr]; ok {
+			t.cfg.Store(&cfg)
+			continue
+		}
+
+		existing[addr] = m.startProbe(addr, cfg)
+	}
+
+	for addr, t := range existing {
+		if !wanted[addr] {
+			t.cancel()
+			delete(existing, addr)
+		}
+	}
+}
+
+// Remove stops every active prober for (svc, port).
+func (m *Manager) Remove(svc types.NamespacedName, port graph.ServicePort) {
+	key := portKey{service: svc, port: port}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.targets[key] {
+		t.cancel()
+	}
+
+	delete(m.targets, key)
+}
+
+// RemoveService stops every active prober belonging to svc, across all of
+// its ports. Called when the Service itself is deleted.
+func (m *Manager) RemoveService(svc types.NamespacedName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, addrs := range m.targets {
+		if key.service != svc {
+			continue
+		}
+
+		for _, t := range addrs {
+			t.cancel()
+		}
+
+		delete(m.targets, key)
+	}
+}
+
+// Healthy reports whether addr should currently receive traffic for (svc,
+// port). Endpoints that aren't actively probed are always considered
+// healthy, so enabling a healthcheck annotation is strictly additive.
+func (m *Manager) Healthy(svc types.NamespacedName, port graph.ServicePort, addr netip.AddrPort) bool {
+	key := portKey{service: svc, port: port}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.targets[key][addr]
+	if !ok {
+		return true
+	}
+
+	return t.healthy.Load()
+}
+
+func (m *Manager) startProbe(addr netip.AddrPort, cfg Config) *target {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	healthy := &atomic.Bool{}
+	healthy.Store(true)
+
+	t := &target{
+		cancel:  cancel,
+		healthy: healthy,
+	}
+	t.cfg.Store(&cfg)
+
+	go m.run(ctx, addr, t)
+
+	return t
+}
+
+func (m *Manager) run(ctx context.Context, addr netip.AddrPort, t *target) {
+	var consecutiveSuccess, consecutiveFailure int
+
+	cfg := *t.cfg.Load()
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cfg = *t.cfg.Load()
+
+		select {
+		case m.sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		err := probe(ctx, addr, cfg)
+		<-m.sem
+
+		if err == nil {
+			consecutiveSuccess++
+			consecutiveFailure = 0
+			if consecutiveSuccess >= cfg.HealthyThreshold {
+				t.healthy.Store(true)
+			}
+		} else {
+			consecutiveFailure++
+			consecutiveSuccess = 0
+			if consecutiveFailure >= cfg.UnhealthyThreshold {
+				t.healthy.Store(false)
+			}
+		}
+
+		// Sync may have swapped in a new interval while the probe above
+		// was in flight; pick it up on the next tick rather than waiting
+		// out the old one.
+		ticker.Reset(t.cfg.Load().Interval)
+	}
+}
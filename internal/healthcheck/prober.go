@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Protocol selects how a single endpoint is probed.
+type Protocol string
+
+const (
+	ProtocolTCP  Protocol = "tcp"
+	ProtocolHTTP Protocol = "http"
+	ProtocolGRPC Protocol = "grpc"
+)
+
+// probe runs a single health check against addr per cfg, returning a non-nil
+// error if the endpoint should be considered unreachable for this attempt.
+func probe(ctx context.Context, addr netip.AddrPort, cfg Config) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	switch cfg.Protocol {
+	case ProtocolHTTP:
+		return probeHTTP(ctx, addr, cfg.HTTPPath)
+	case ProtocolGRPC:
+		return probeGRPC(ctx, addr)
+	default:
+		return probeTCP(ctx, addr)
+	}
+}
+
+func probeTCP(ctx context.Context, addr netip.AddrPort) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func probeHTTP(ctx context.Context, addr netip.AddrPort, path string) error {
+	if path == "" {
+		path = "/"
+	}
+
+	url := fmt.Sprintf("http://%s%s", addr.String(), path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("http healthcheck: unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func probeGRPC(ctx context.Context, addr netip.AddrPort) error {
+	conn, err := grpc.NewClient(addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthv1.NewHealthClient(conn).Check(ctx, &healthv1.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != healthv1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc healthcheck: status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package xds translates the graph package's view of Services into Envoy xDS
+// resources.
+package xds
+
+import (
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/eplightning/xds-servicelb/internal/graph"
+)
+
+const activeEndpointWeight = 1
+
+// buildLbEndpoint converts a single ServiceEndpoint into an Envoy LbEndpoint,
+// applying the ready/serving-terminating health status described on
+// graph.EndpointState. Terminating endpoints keep the same weight (Envoy's
+// LbEndpoint.load_balancing_weight must be >= 1, or the whole
+// ClusterLoadAssignment is NACKed) and instead rely on HealthStatus_DRAINING
+// to stop new traffic while letting existing connections finish.
+func buildLbEndpoint(ep graph.ServiceEndpoint) *endpointv3.LbEndpoint {
+	weight := uint32(activeEndpointWeight)
+	healthStatus := corev3.HealthStatus_HEALTHY
+
+	if ep.State == graph.EndpointServingTerminating {
+		healthStatus = corev3.HealthStatus_DRAINING
+	}
+
+	return &endpointv3.LbEndpoint{
+		HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+			Endpoint: &endpointv3.Endpoint{
+				Address: addressFor(ep),
+			},
+		},
+		HealthStatus:        healthStatus,
+		LoadBalancingWeight: wrapperspb.UInt32(weight),
+	}
+}
+
+func addressFor(ep graph.ServiceEndpoint) *corev3.Address {
+	return &corev3.Address{
+		Address: &corev3.Address_SocketAddress{
+			SocketAddress: &corev3.SocketAddress{
+				Address:       ep.AddrPort.Addr().String(),
+				PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(ep.AddrPort.Port())},
+				Protocol:      socketProtocolFor(ep.Protocol.String()),
+			},
+		},
+	}
+}
+
+func socketProtocolFor(protocol string) corev3.SocketAddress_Protocol {
+	if protocol == "UDP" {
+		return corev3.SocketAddress_UDP
+	}
+
+	return corev3.SocketAddress_TCP
+}
+
+// localZonePriority and remoteZonePriority rank localities so Envoy only
+// spills traffic into a remote zone once the local one has no healthy
+// endpoints left, per Envoy's locality-weighted priority semantics.
+const (
+	localZonePriority  = 0
+	remoteZonePriority = 1
+)
+
+// BuildClusterLoadAssignment builds the EDS ClusterLoadAssignment for a
+// single ServicePort. When data.TopologyAware is set (the Service opted into
+// topology-aware routing), endpoints are grouped into localities by zone so
+// Envoy prefers localZone and only falls back to other zones when it runs
+// out of healthy local endpoints; otherwise every endpoint goes into a
+// single priority-0 locality, since demoting cross-zone endpoints is only
+// correct for Services that asked for zone-local routing.
+func BuildClusterLoadAssignment(clusterName, localZone string, data graph.ServicePortData) *endpointv3.ClusterLoadAssignment {
+	lbEndpoints := make([]*endpointv3.LbEndpoint, 0, len(data.Endpoints))
+	for _, ep := range data.Endpoints {
+		lbEndpoints = append(lbEndpoints, buildLbEndpoint(ep))
+	}
+
+	if !data.TopologyAware {
+		return &endpointv3.ClusterLoadAssignment{
+			ClusterName: clusterName,
+			Endpoints: []*endpointv3.LocalityLbEndpoints{
+				{
+					Priority:    localZonePriority,
+					LbEndpoints: lbEndpoints,
+				},
+			},
+		}
+	}
+
+	byZone := make(map[string][]*endpointv3.LbEndpoint)
+	var zoneOrder []string
+
+	for i, ep := range data.Endpoints {
+		if _, ok := byZone[ep.Zone]; !ok {
+			zoneOrder = append(zoneOrder, ep.Zone)
+		}
+
+		byZone[ep.Zone] = append(byZone[ep.Zone], lbEndpoints[i])
+	}
+
+	hasLocalZone := false
+	for _, zone := range zoneOrder {
+		if zone == localZone || localZone == "" {
+			hasLocalZone = true
+			break
+		}
+	}
+
+	localities := make([]*endpointv3.LocalityLbEndpoints, 0, len(zoneOrder))
+	for _, zone := range zoneOrder {
+		priority := uint32(remoteZonePriority)
+		if zone == localZone || localZone == "" || !hasLocalZone {
+			// Falling back to priority 0 for every locality when none
+			// matches localZone keeps priorities dense starting at 0, as
+			// Envoy requires; otherwise a configured-but-absent localZone
+			// would leave every locality at priority 1 with a gap at 0.
+			priority = localZonePriority
+		}
+
+		localities = append(localities, &endpointv3.LocalityLbEndpoints{
+			Locality:    &corev3.Locality{Zone: zone},
+			Priority:    priority,
+			LbEndpoints: byZone[zone],
+		})
+	}
+
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   localities,
+	}
+}